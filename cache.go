@@ -1,29 +1,43 @@
 package main
 
 import (
-	"errors"
+	"container/list"
+	"encoding/json"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"sync"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 // Cache remembers seen Repostories and provides goroutine-safe methods for
-// adding values or retrieving random results.
+// adding values or retrieving random results. Entries are held in insertion
+// order so the oldest one can be evicted in O(1) once maxSize is reached,
+// and each entry expires TTL after it was fetched.
 type Cache struct {
-	data map[int]Repository
-	ids  []int
-	lock *sync.RWMutex
+	data    map[int]*list.Element // id -> element in order, Value is a Repository
+	order   *list.List            // front is oldest, back is newest
+	lock    *sync.RWMutex
+	maxSize int           // 0 means unbounded
+	ttl     time.Duration // 0 means entries never expire
 }
 
-// NewCache creates a cache ready for use.
-func NewCache() *Cache {
+// NewCache creates a cache ready for use. A maxSize of 0 means the cache may
+// grow without bound, and a ttl of 0 means entries never expire.
+func NewCache(maxSize int, ttl time.Duration) *Cache {
 	return &Cache{
-		data: make(map[int]Repository),
-		ids:  nil,
-		lock: &sync.RWMutex{},
+		data:    make(map[int]*list.Element),
+		order:   list.New(),
+		lock:    &sync.RWMutex{},
+		maxSize: maxSize,
+		ttl:     ttl,
 	}
 }
 
-// Add puts another repository in the cache. It does not replace duplicate records.
+// Add puts another repository in the cache. It does not replace duplicate
+// records. If adding it would exceed maxSize the oldest entry is evicted.
 func (c *Cache) Add(r Repository) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -31,27 +45,44 @@ func (c *Cache) Add(r Repository) {
 		return
 	}
 
-	c.data[r.ID] = r
-	c.ids = append(c.ids, r.ID)
+	if c.maxSize > 0 && c.order.Len() >= c.maxSize {
+		oldest := c.order.Front()
+		if oldest != nil {
+			delete(c.data, oldest.Value.(Repository).ID)
+			c.order.Remove(oldest)
+		}
+	}
+
+	elem := c.order.PushBack(r)
+	c.data[r.ID] = elem
 }
 
 // GetRandom attempts to pick a random Repository from its data. If a non-nil
 // map of values to exclude is provided then those records will not be
-// considered. It returns an error if it cannot find a record to return.
+// considered. Expired entries are evicted as they are encountered. It
+// returns an error if it cannot find a record to return.
 func (c *Cache) GetRandom(exclude map[int]bool) (Repository, error) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
 
 	var available []int
+	for e := c.order.Front(); e != nil; {
+		next := e.Next()
+		r := e.Value.(Repository)
+
+		if c.ttl > 0 && now.Sub(r.FetchedAt) > c.ttl {
+			delete(c.data, r.ID)
+			c.order.Remove(e)
+			e = next
+			continue
+		}
 
-	if exclude == nil {
-		available = c.ids
-	} else {
-		for _, id := range c.ids {
-			if !exclude[id] {
-				available = append(available, id)
-			}
+		if exclude == nil || !exclude[r.ID] {
+			available = append(available, r.ID)
 		}
+		e = next
 	}
 
 	if len(available) == 0 {
@@ -60,7 +91,74 @@ func (c *Cache) GetRandom(exclude map[int]bool) (Repository, error) {
 
 	index := rand.Intn(len(available))
 	id := available[index]
-	r := c.data[id]
+	r := c.data[id].Value.(Repository)
 
 	return r, nil
 }
+
+// cacheSnapshot is the on-disk representation written by Save and read by
+// Load, oldest entry first.
+type cacheSnapshot struct {
+	Repositories []Repository `json:"repositories"`
+}
+
+// Save writes the cache's current contents to path as JSON, replacing the
+// file atomically so a concurrent Load never sees a partial write.
+func (c *Cache) Save(path string) error {
+	c.lock.RLock()
+	repos := make([]Repository, 0, c.order.Len())
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		repos = append(repos, e.Value.(Repository))
+	}
+	c.lock.RUnlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "creating temp file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(cacheSnapshot{Repositories: repos}); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "encoding cache snapshot")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "closing temp file")
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads a snapshot previously written by Save and merges it into the
+// cache. It is a no-op if path does not exist, which is the normal case on
+// a fresh deployment.
+func (c *Cache) Load(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "opening cache file")
+	}
+	defer f.Close()
+
+	var snap cacheSnapshot
+	if err := json.NewDecoder(f).Decode(&snap); err != nil {
+		return errors.Wrap(err, "decoding cache snapshot")
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, r := range snap.Repositories {
+		if _, ok := c.data[r.ID]; ok {
+			continue
+		}
+		if c.maxSize > 0 && c.order.Len() >= c.maxSize {
+			break
+		}
+		elem := c.order.PushBack(r)
+		c.data[r.ID] = elem
+	}
+
+	return nil
+}
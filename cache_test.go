@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheAddEvictsOldestWhenFull(t *testing.T) {
+	c := NewCache(2, 0)
+
+	c.Add(Repository{ID: 1, Name: "one", FetchedAt: time.Now()})
+	c.Add(Repository{ID: 2, Name: "two", FetchedAt: time.Now()})
+	c.Add(Repository{ID: 3, Name: "three", FetchedAt: time.Now()})
+
+	if _, err := c.GetRandom(map[int]bool{2: true, 3: true}); err == nil {
+		t.Fatal("expected id 1 to have been evicted, but GetRandom found a record")
+	}
+	if _, err := c.GetRandom(map[int]bool{1: true, 3: true}); err != nil {
+		t.Fatalf("expected id 2 to still be cached: %v", err)
+	}
+	if _, err := c.GetRandom(map[int]bool{1: true, 2: true}); err != nil {
+		t.Fatalf("expected id 3 to still be cached: %v", err)
+	}
+}
+
+func TestCacheAddIgnoresDuplicates(t *testing.T) {
+	c := NewCache(0, 0)
+
+	c.Add(Repository{ID: 1, Name: "first", FetchedAt: time.Now()})
+	c.Add(Repository{ID: 1, Name: "second", FetchedAt: time.Now()})
+
+	r, err := c.GetRandom(nil)
+	if err != nil {
+		t.Fatalf("GetRandom: %v", err)
+	}
+	if r.Name != "first" {
+		t.Fatalf("expected duplicate Add to be a no-op, got name %q", r.Name)
+	}
+}
+
+func TestCacheGetRandomExpiresEntriesPastTTL(t *testing.T) {
+	c := NewCache(0, time.Minute)
+
+	c.Add(Repository{ID: 1, Name: "stale", FetchedAt: time.Now().Add(-2 * time.Minute)})
+	c.Add(Repository{ID: 2, Name: "fresh", FetchedAt: time.Now()})
+
+	r, err := c.GetRandom(nil)
+	if err != nil {
+		t.Fatalf("GetRandom: %v", err)
+	}
+	if r.ID != 2 {
+		t.Fatalf("expected only the fresh entry to survive, got id %d", r.ID)
+	}
+
+	if _, err := c.GetRandom(map[int]bool{2: true}); err == nil {
+		t.Fatal("expected expired entry to be evicted, but GetRandom found a record")
+	}
+}
+
+func TestCacheGetRandomErrorsWhenEmpty(t *testing.T) {
+	c := NewCache(0, 0)
+
+	if _, err := c.GetRandom(nil); err == nil {
+		t.Fatal("expected an error from an empty cache")
+	}
+}
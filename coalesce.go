@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// group coalesces the upstream fan-out for concurrent List calls that share
+// a Coalescer key. One caller (the "producer", see RepositoryHandlers.produce)
+// drives the actual query goroutines and appends each result here as it
+// arrives, already deduplicated against its own `seen` map (a duplicate is
+// dropped and replaced rather than appended); every other caller for the
+// same key (a "waiter") reads that same shared stream.
+//
+// Each waiter still keeps its own `seen` map, starting empty for each
+// request: it records the shared results that caller took plus the entries
+// it then backfills from the Cache, so its own backfill does not repeat an
+// ID it already returned.
+type group struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	results  []Repository
+	finished bool
+}
+
+func newGroup() *group {
+	g := &group{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// append adds a result to the group and wakes any waiters blocked in
+// sinceWait.
+func (g *group) append(r Repository) {
+	g.mu.Lock()
+	g.results = append(g.results, r)
+	g.cond.Broadcast()
+	g.mu.Unlock()
+}
+
+// finish marks the group as done; no more results will be appended.
+func (g *group) finish() {
+	g.mu.Lock()
+	g.finished = true
+	g.cond.Broadcast()
+	g.mu.Unlock()
+}
+
+// sinceWait blocks until the group holds a result at index from or later,
+// the group finishes, or ctx is done, then returns every result from index
+// from onward.
+func (g *group) sinceWait(ctx context.Context, from int) (results []Repository, finished bool) {
+	// Cond.Wait has no notion of a context, so wake ourselves with a
+	// Broadcast when ctx is done instead of blocking forever.
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			g.mu.Lock()
+			g.cond.Broadcast()
+			g.mu.Unlock()
+		case <-stop:
+		}
+	}()
+	defer close(stop)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for len(g.results) <= from && !g.finished && ctx.Err() == nil {
+		g.cond.Wait()
+	}
+
+	return append([]Repository(nil), g.results[from:]...), g.finished
+}
+
+// Coalescer lets concurrent List calls for the same count/unique/timeout
+// bucket share a single upstream fan-out instead of each starting their own.
+type Coalescer struct {
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+// NewCoalescer creates a Coalescer ready for use.
+func NewCoalescer() *Coalescer {
+	return &Coalescer{groups: make(map[string]*group)}
+}
+
+// coalesceKey normalizes the parameters that drive a fan-out so requests
+// that want equivalent results end up in the same group. timeout is rounded
+// to the nearest 100ms bucket so near-simultaneous requests with slightly
+// different deadlines still coalesce.
+func coalesceKey(count int, unique bool, timeout time.Duration) string {
+	return fmt.Sprintf("count=%d&unique=%t&timeout=%s", count, unique, timeout.Round(100*time.Millisecond))
+}
+
+// Join attaches the caller to the in-progress group for key, creating one if
+// necessary. isLeader is true for whichever caller created the group; only
+// the leader is responsible for driving the fan-out.
+func (c *Coalescer) Join(key string) (g *group, isLeader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if existing, ok := c.groups[key]; ok {
+		return existing, false
+	}
+
+	g = newGroup()
+	c.groups[key] = g
+	return g, true
+}
+
+// Leave removes the group for key so later requests start a fresh fan-out
+// instead of reattaching to a finished one.
+func (c *Coalescer) Leave(key string) {
+	c.mu.Lock()
+	delete(c.groups, key)
+	c.mu.Unlock()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCoalescerJoinSharesGroupUntilLeave(t *testing.T) {
+	c := NewCoalescer()
+
+	g1, leader1 := c.Join("key")
+	if !leader1 {
+		t.Fatal("first Join should be the leader")
+	}
+
+	g2, leader2 := c.Join("key")
+	if leader2 {
+		t.Fatal("second Join for the same key should not be the leader")
+	}
+	if g1 != g2 {
+		t.Fatal("second Join should return the same group as the first")
+	}
+
+	c.Leave("key")
+
+	g3, leader3 := c.Join("key")
+	if !leader3 {
+		t.Fatal("Join after Leave should start a fresh group and be the leader")
+	}
+	if g3 == g1 {
+		t.Fatal("Join after Leave should not reattach to the finished group")
+	}
+}
+
+func TestGroupSinceWaitReturnsOnlyResultsAfterFrom(t *testing.T) {
+	g := newGroup()
+
+	g.append(Repository{ID: 1})
+	g.append(Repository{ID: 2})
+
+	got, finished := g.sinceWait(context.Background(), 1)
+	if finished {
+		t.Fatal("group should not be finished yet")
+	}
+	if len(got) != 1 || got[0].ID != 2 {
+		t.Fatalf("sinceWait(1) = %+v, want only id 2", got)
+	}
+}
+
+func TestGroupSinceWaitWakesOnAppend(t *testing.T) {
+	g := newGroup()
+
+	done := make(chan []Repository, 1)
+	go func() {
+		got, _ := g.sinceWait(context.Background(), 0)
+		done <- got
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	g.append(Repository{ID: 42})
+
+	select {
+	case got := <-done:
+		if len(got) != 1 || got[0].ID != 42 {
+			t.Fatalf("sinceWait woke with %+v, want id 42", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sinceWait did not wake up after append")
+	}
+}
+
+func TestGroupSinceWaitWakesOnFinish(t *testing.T) {
+	g := newGroup()
+
+	done := make(chan bool, 1)
+	go func() {
+		_, finished := g.sinceWait(context.Background(), 0)
+		done <- finished
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	g.finish()
+
+	select {
+	case finished := <-done:
+		if !finished {
+			t.Fatal("sinceWait woke with finished=false after finish()")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sinceWait did not wake up after finish")
+	}
+}
+
+func TestGroupSinceWaitWakesOnContextCancel(t *testing.T) {
+	g := newGroup()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{}, 1)
+	go func() {
+		g.sinceWait(ctx, 0)
+		done <- struct{}{}
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sinceWait did not wake up after context cancellation")
+	}
+}
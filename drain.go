@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Drainer tracks long-running background goroutines that are not owned by
+// any single inbound HTTP request — such as a coalesced group's producer —
+// so the process can wait for them to finish during graceful shutdown
+// instead of killing them mid-flight.
+type Drainer struct {
+	wg sync.WaitGroup
+}
+
+// Go launches fn in a new goroutine tracked by the Drainer.
+func (d *Drainer) Go(fn func()) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		fn()
+	}()
+}
+
+// Wait blocks until every goroutine launched through Go has returned, or
+// until ctx is done, whichever happens first. It returns ctx.Err() if it
+// timed out waiting.
+func (d *Drainer) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
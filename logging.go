@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+type requestIDKey struct{}
+
+// newRequestID generates a short random hex identifier suitable for
+// correlating the log lines produced by one inbound HTTP request.
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand.Read only fails if the OS entropy source is broken,
+		// which isn't something callers can recover from; fall back to an
+		// obviously-unset ID rather than panicking.
+		return "unknown"
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// requestIDFromContext returns the request ID stored in ctx, or "" if none
+// was set.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestID middleware assigns every inbound request a correlation ID
+// and stores it on the request's context so the log lines List produces
+// while handling it can be grepped back to this one request. The upstream
+// fan-out in RepositoryHandlers.query may be shared by several coalesced
+// requests, so its log lines carry a "group:<key>" ID (see
+// RepositoryHandlers.produce) rather than this one.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), requestIDKey{}, newRequestID())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -5,52 +5,175 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func main() {
 	addr := flag.String("addr", ":8080", "ip:port for the service to bind to")
+	debugAddr := flag.String("debug-addr", ":6060", "ip:port for the debug service (pprof, metrics) to bind to")
 	backend := flag.String("backend", "http://localhost:7080", "url for the upstream code host API")
+	drainTimeout := flag.Duration("drain-timeout", 10*time.Second, "how long to wait for in-flight requests to finish during shutdown")
+	retryBase := flag.Duration("retry-base", 100*time.Millisecond, "base delay for exponential backoff between upstream retries")
+	retryMax := flag.Duration("retry-max", 5*time.Second, "maximum delay between upstream retries")
+	retryMaxElapsed := flag.Duration("retry-max-elapsed", 0, "maximum total time to keep retrying an upstream call before giving up (0 means retry until the request context is done)")
+	cacheSize := flag.Int("cache-size", 10000, "maximum number of repositories to keep in the cache (0 means unbounded)")
+	cacheTTL := flag.Duration("cache-ttl", 24*time.Hour, "how long a cached repository remains eligible for backfill before expiring (0 means it never expires)")
+	cacheFile := flag.String("cache-file", "", "optional path to persist the cache across restarts")
+	cacheSaveInterval := flag.Duration("cache-save-interval", time.Minute, "how often to write the cache to -cache-file")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/gRPC collector address to export traces to (tracing is a no-op when unset)")
 
 	flag.Parse()
 
-	logger := log.New(os.Stdout, "proxy : ", log.LstdFlags|log.Lshortfile)
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	shutdownTracing, err := setupTracing(context.Background(), *otlpEndpoint)
+	if err != nil {
+		logger.Error("could not set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	metrics := NewMetrics(prometheus.DefaultRegisterer)
+
+	retry := RetryPolicy{
+		Base:       *retryBase,
+		Max:        *retryMax,
+		MaxElapsed: *retryMaxElapsed,
+	}
+
+	cache := NewCache(*cacheSize, *cacheTTL)
+	if *cacheFile != "" {
+		if err := cache.Load(*cacheFile); err != nil {
+			logger.Error("could not load cache file", "error", err)
+		}
+	}
+
+	// shutdownCtx is the parent context for every coalesced group's
+	// producer (see RepositoryHandlers.produce). Canceling it at the start
+	// of shutdown lets outstanding upstream retries stop promptly instead
+	// of running until their own (possibly long) per-request timeout, and
+	// drain tracks them so main can wait for that unwind to finish.
+	shutdownCtx, cancelShutdownCtx := context.WithCancel(context.Background())
+	defer cancelShutdownCtx()
+	drain := &Drainer{}
+
+	cacheSaveDone := make(chan struct{})
+	if *cacheFile != "" {
+		go func() {
+			ticker := time.NewTicker(*cacheSaveInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := cache.Save(*cacheFile); err != nil {
+						logger.Error("could not save cache file", "error", err)
+					}
+				case <-cacheSaveDone:
+					return
+				}
+			}
+		}()
+	}
 
 	srv := http.Server{
 		Addr:    *addr,
-		Handler: app(*backend, logger),
+		Handler: app(*backend, logger, metrics, retry, cache, shutdownCtx, drain),
+	}
+
+	debugSrv := http.Server{
+		Addr:    *debugAddr,
+		Handler: debugHandler(),
+	}
+
+	go func() {
+		logger.Info("debug server listening", "addr", debugSrv.Addr)
+		if err := debugSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("debug server error", "error", err)
+		}
+	}()
+
+	go func() {
+		logger.Info("server listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("server error", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	logger.Info("shutdown signal received, draining", "drain_timeout", drainTimeout.String())
+
+	// Stop driving any in-progress coalesced fan-outs immediately so their
+	// goroutines start unwinding while we wait below, rather than running
+	// for as long as their (client-controlled) request timeout allows.
+	cancelShutdownCtx()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("error during shutdown", "error", err)
+	}
+	if err := debugSrv.Shutdown(ctx); err != nil {
+		logger.Error("error shutting down debug server", "error", err)
 	}
 
-	logger.Println("server listening on", srv.Addr)
-	logger.Fatal(srv.ListenAndServe())
+	if err := drain.Wait(ctx); err != nil {
+		logger.Error("timed out waiting for in-flight upstream fan-outs to finish", "error", err)
+	}
+
+	if *cacheFile != "" {
+		close(cacheSaveDone)
+		if err := cache.Save(*cacheFile); err != nil {
+			logger.Error("could not save cache file", "error", err)
+		}
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("error shutting down tracing", "error", err)
+	}
+
+	logger.Info("shutdown complete")
 
-	// TODO: Add graceful shutdown
-	// TODO: Add distributed tracing
-	// TODO: Add metrics
-	// TODO: Add debug service with pprof
 	// TODO: Add tests
 }
 
-func app(backend string, logger *log.Logger) http.Handler {
+func app(backend string, logger *slog.Logger, metrics *Metrics, retry RetryPolicy, cache *Cache, shutdownCtx context.Context, drain *Drainer) http.Handler {
 
 	mux := http.NewServeMux()
 
 	rh := RepositoryHandlers{
 		CodeHostURL: backend,
-		Client:      &http.Client{},
+		Client:      &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
 		Log:         logger,
-		Cache:       NewCache(),
+		Cache:       cache,
+		Metrics:     metrics,
+		Retry:       retry,
+		// TODO: decide on the appropriate size for this.
+		Pool:        NewQueryPool(10),
+		Coalesce:    NewCoalescer(),
+		ShutdownCtx: shutdownCtx,
+		Drain:       drain,
 	}
 
 	mux.HandleFunc("/repositories", rh.List)
 
-	return mux
+	return otelhttp.NewHandler(withRequestID(mux), "proxy")
 }
 
 // Repository is the main data record we are proxying from the upstream API.
@@ -64,8 +187,14 @@ type Repository struct {
 type RepositoryHandlers struct {
 	CodeHostURL string
 	Client      *http.Client
-	Log         *log.Logger
+	Log         *slog.Logger
 	Cache       *Cache
+	Metrics     *Metrics
+	Retry       RetryPolicy
+	Pool        *QueryPool
+	Coalesce    *Coalescer
+	ShutdownCtx context.Context
+	Drain       *Drainer
 }
 
 // List calls an upstream server to get a list of repositories.
@@ -100,47 +229,60 @@ func (rh *RepositoryHandlers) List(w http.ResponseWriter, r *http.Request) {
 	// need to keep allocating new backing arrays.
 	repos := make([]Repository, 0, count)
 
-	ctx, cancel := context.WithTimeout(r.Context(), timeout)
-	defer cancel()
+	start := time.Now()
+	if rh.Metrics != nil {
+		defer func() {
+			rh.Metrics.RequestLatency.Observe(time.Since(start).Seconds())
+		}()
+	}
 
-	ch := make(chan Repository, count)
+	spanCtx, span := tracer.Start(r.Context(), "RepositoryHandlers.List", trace.WithAttributes(
+		attribute.Int("count", count),
+		attribute.String("timeout", timeout.String()),
+		attribute.Bool("unique", seen != nil),
+	))
+	defer span.End()
 
-	// sem is used to prevent the proxy from starting more than cap(sem)
-	// concurrent requests upstream.
-	// TODO: decide on the appropriate size for this.
-	sem := make(chan struct{}, 10)
+	ctx, cancel := context.WithTimeout(spanCtx, timeout)
+	defer cancel()
 
-	var gid int
-	for gid = 0; gid < count; gid++ {
-		go rh.query(ctx, gid, sem, ch)
+	// Concurrent callers asking for the same count/unique/timeout bucket
+	// share one upstream fan-out instead of each starting their own; only
+	// the caller that creates the group (the "producer") drives it.
+	key := coalesceKey(count, seen != nil, timeout)
+	g, isLeader := rh.Coalesce.Join(key)
+	if isLeader {
+		rh.produce(key, count, seen != nil, timeout, spanCtx, g)
 	}
 
-loop:
-	for {
-		select {
-		case <-ctx.Done():
-			break loop
-
-		case repo := <-ch:
+	idx := 0
+	for len(repos) < count {
+		batch, finished := g.sinceWait(ctx, idx)
+		idx += len(batch)
 
-			// If we only want unique results and we've seen this one before then
-			// schedule another goroutine to account for this duplicate.
+		for _, repo := range batch {
+			// If we only want unique results and we've seen this one before
+			// then skip it; on top of the producer's own dedup, each caller
+			// tracks its own `seen` set over the shared stream.
 			if seen != nil {
 				if seen[repo.ID] {
-					go rh.query(ctx, gid, sem, ch)
-					gid++
-					break
+					if rh.Metrics != nil {
+						rh.Metrics.DuplicatesRejected.Inc()
+					}
+					continue
 				}
 				seen[repo.ID] = true
 			}
 
-			rh.Cache.Add(repo)
 			repos = append(repos, repo)
-
 			if len(repos) == count {
-				break loop
+				break
 			}
 		}
+
+		if finished || ctx.Err() != nil {
+			break
+		}
 	}
 
 	// If we broke the loop with less than the desired records then we need to
@@ -156,9 +298,12 @@ loop:
 
 		repo, err := rh.Cache.GetRandom(seen)
 		if err != nil {
-			rh.Log.Println(err)
+			rh.Log.Error("cache backfill failed", "request_id", requestIDFromContext(ctx), "error", err)
 			break
 		}
+		if rh.Metrics != nil {
+			rh.Metrics.CacheBackfills.Inc()
+		}
 		if seen != nil {
 			seen[repo.ID] = true
 		}
@@ -174,7 +319,7 @@ loop:
 
 	data, err := json.Marshal(result)
 	if err != nil {
-		rh.Log.Println("could not marshal results", err)
+		rh.Log.Error("could not marshal results", "request_id", requestIDFromContext(ctx), "error", err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
@@ -183,62 +328,206 @@ loop:
 	w.Write(data)
 }
 
-func (rh *RepositoryHandlers) query(ctx context.Context, id int, sem chan struct{}, results chan<- Repository) {
+// produce drives the upstream fan-out for a coalesced group of List calls.
+// It runs detached from any single caller's request context, since the
+// group may outlive the request that happened to create it, and stops once
+// it has gathered count results, timeout elapses, or the process starts
+// shutting down. rh.Drain tracks it so main can wait for it to unwind
+// during graceful shutdown instead of killing it mid-flight, and it is
+// rooted at rh.ShutdownCtx so shutdown cancels it promptly rather than
+// letting it run for the full (client-controlled) timeout. leaderSpanCtx
+// carries only the leader's span context (not its cancellation), so every
+// query span this fan-out creates still shows up as a child of the List
+// span that triggered it, even though the group's own lifetime is tied to
+// rh.ShutdownCtx rather than that caller's request context.
+func (rh *RepositoryHandlers) produce(key string, count int, unique bool, timeout time.Duration, leaderSpanCtx context.Context, g *group) {
+	rh.Drain.Go(func() {
+		ctx, cancel := context.WithTimeout(rh.ShutdownCtx, timeout)
+		ctx = trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(leaderSpanCtx))
+		ctx = context.WithValue(ctx, requestIDKey{}, "group:"+key)
+
+		// rp tracks every goroutine this fan-out launches so we can wait
+		// for them all to exit before the group is torn down, instead of
+		// leaking them (and the Pool slots they hold) past its lifetime.
+		// cancel must run before rp.WaitRequest() blocks on them, or they
+		// just keep retrying against the upstream for the full timeout
+		// after the response has already gone out.
+		rp := rh.Pool.Request()
+		defer func() {
+			cancel()
+			rp.WaitRequest()
+			g.finish()
+			rh.Coalesce.Leave(key)
+		}()
+
+		ch := make(chan Repository, count)
+
+		// seen is the producer's own view of which IDs it has already
+		// streamed: a duplicate is kept out of the shared stream and a
+		// replacement goroutine is launched for it. Each waiter in the group
+		// still keeps its own seen map over that stream; this one just keeps
+		// the group supplied with enough distinct results.
+		var seen map[int]bool
+		if unique {
+			seen = make(map[int]bool)
+		}
 
-	// Driver loop that retries until the context is canceled and respects the semaphore.
-	for {
+		var gid int
+		for gid = 0; gid < count; gid++ {
+			id := gid
+			rp.Go(func() { rh.query(ctx, id, ch) })
+		}
 
-		// If the context is done then give up.
-		select {
-		case <-ctx.Done():
+		got := 0
+		for got < count {
+			select {
+			case <-ctx.Done():
+				return
+
+			case repo := <-ch:
+				if seen != nil {
+					if seen[repo.ID] {
+						if rh.Metrics != nil {
+							rh.Metrics.DuplicatesRejected.Inc()
+						}
+						id := gid
+						rp.Go(func() { rh.query(ctx, id, ch) })
+						gid++
+						continue
+					}
+					seen[repo.ID] = true
+				}
+
+				rh.Cache.Add(repo)
+				g.append(repo)
+				got++
+			}
+		}
+	})
+}
+
+func (rh *RepositoryHandlers) query(ctx context.Context, id int, results chan<- Repository) {
+
+	start := time.Now()
+	reqID := requestIDFromContext(ctx)
+
+	// Driver loop that retries with exponential backoff until the context is
+	// canceled or Retry.MaxElapsed has passed, respecting the process-wide
+	// Pool concurrency limit.
+	for attempt := 0; ; attempt++ {
+
+		if err := rh.Pool.Acquire(ctx); err != nil {
 			return
+		}
+
+		attemptCtx, span := tracer.Start(ctx, "RepositoryHandlers.query", trace.WithAttributes(
+			attribute.Int("goroutine_id", id),
+			attribute.Int("attempt", attempt),
+		))
 
-		// If we can push a value onto the semaphore then we can start calling.
-		case sem <- struct{}{}:
-			rh.Log.Printf("%d : started", id)
-			repo, err := rh.queryCall(ctx)
+		callStart := time.Now()
+		repo, statusCode, err := rh.queryCall(attemptCtx)
+		duration := time.Since(callStart)
 
-			// Take a value out of the semaphore to let another goroutine in.
-			<-sem
+		rh.Pool.Release()
 
-			if err != nil {
-				rh.Log.Printf("%d : ERROR %v", id, err)
-				continue
+		span.SetAttributes(
+			attribute.Int("upstream_status", statusCode),
+			attribute.Bool("from_cache", false),
+		)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+
+		attrs := []any{
+			"request_id", reqID,
+			"goroutine_id", id,
+			"upstream_url", rh.CodeHostURL,
+			"attempt", attempt,
+			"duration_ms", duration.Milliseconds(),
+		}
+
+		if err != nil {
+			rh.Log.Error("upstream call failed", append(attrs, "status", "error", "error", err.Error())...)
+
+			if rh.Retry.MaxElapsed > 0 && time.Since(start) > rh.Retry.MaxElapsed {
+				rh.Log.Error("giving up on upstream call", "request_id", reqID, "goroutine_id", id, "attempts", attempt+1)
+				return
 			}
 
-			// Success!
-			results <- repo
-			rh.Log.Printf("%d : completed", id)
-			return
+			if err := rh.Retry.Sleep(ctx, attempt); err != nil {
+				return
+			}
+			continue
+		}
+
+		rh.Log.Info("upstream call succeeded", append(attrs, "status", "ok")...)
+
+		// Send on results, but don't block forever if our caller has
+		// already moved on and stopped reading from the channel.
+		select {
+		case results <- repo:
+		case <-ctx.Done():
 		}
+		return
 	}
 }
 
-func (rh *RepositoryHandlers) queryCall(ctx context.Context) (Repository, error) {
+func (rh *RepositoryHandlers) queryCall(ctx context.Context) (repo Repository, statusCode int, err error) {
+	start := time.Now()
+	defer func() {
+		if rh.Metrics == nil {
+			return
+		}
+		rh.Metrics.UpstreamLatency.Observe(time.Since(start).Seconds())
+		if err != nil {
+			rh.Metrics.UpstreamCalls.WithLabelValues("error").Inc()
+			rh.Metrics.UpstreamFailures.WithLabelValues(failureReason(err)).Inc()
+		} else {
+			rh.Metrics.UpstreamCalls.WithLabelValues("ok").Inc()
+		}
+	}()
+
 	api := rh.CodeHostURL + "/repository?failRatio=0.7"
 	req, err := http.NewRequest(http.MethodGet, api, nil)
 	if err != nil {
-		return Repository{}, errors.Wrap(err, "constructing url")
+		return Repository{}, 0, errors.Wrap(err, "constructing url")
 	}
 
 	req = req.WithContext(ctx)
 
 	res, err := rh.Client.Do(req)
 	if err != nil {
-		return Repository{}, errors.Wrap(err, "calling API")
+		return Repository{}, 0, errors.Wrap(err, "calling API")
 	}
 	defer res.Body.Close()
 
+	statusCode = res.StatusCode
 	if res.StatusCode != http.StatusOK {
-		return Repository{}, fmt.Errorf("api responded %d", res.StatusCode)
+		return Repository{}, statusCode, fmt.Errorf("api responded %d", res.StatusCode)
 	}
 
 	var response struct {
 		Repository Repository `json:"repository"`
 	}
 	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
-		return Repository{}, errors.Wrap(err, "decoding response")
+		return Repository{}, statusCode, errors.Wrap(err, "decoding response")
 	}
 
-	return response.Repository, nil
+	return response.Repository, statusCode, nil
+}
+
+// failureReason buckets an upstream error into a small, low-cardinality label
+// suitable for a metrics dimension.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "other"
+	}
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics holds the Prometheus collectors for the proxy. A single instance is
+// shared by all RepositoryHandlers so every upstream call, regardless of
+// which request spawned it, is counted against the same series.
+type Metrics struct {
+	UpstreamCalls      *prometheus.CounterVec
+	UpstreamFailures   *prometheus.CounterVec
+	DuplicatesRejected prometheus.Counter
+	CacheBackfills     prometheus.Counter
+	UpstreamLatency    prometheus.Histogram
+	RequestLatency     prometheus.Histogram
+}
+
+// NewMetrics registers the proxy's collectors with the given registerer and
+// returns the Metrics ready for use.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	factory := promauto.With(reg)
+
+	return &Metrics{
+		UpstreamCalls: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "upstream_calls_total",
+			Help:      "Total number of calls made to the upstream code host API.",
+		}, []string{"status"}),
+		UpstreamFailures: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "upstream_failures_total",
+			Help:      "Total number of upstream calls that returned an error.",
+		}, []string{"reason"}),
+		DuplicatesRejected: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "duplicates_rejected_total",
+			Help:      "Total number of repositories rejected because unique=true and the ID was already seen.",
+		}),
+		CacheBackfills: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: "proxy",
+			Name:      "cache_backfills_total",
+			Help:      "Total number of results filled in from the cache instead of the upstream API.",
+		}),
+		UpstreamLatency: factory.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "proxy",
+			Name:      "upstream_call_duration_seconds",
+			Help:      "Latency of individual upstream API calls.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		RequestLatency: factory.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "proxy",
+			Name:      "request_duration_seconds",
+			Help:      "End-to-end latency of /repositories requests.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// QueryPool bounds the number of concurrent upstream calls the process will
+// make at any moment, no matter how many inbound HTTP requests are being
+// served. It is created once and shared by every RepositoryHandlers.
+type QueryPool struct {
+	sem chan struct{}
+}
+
+// NewQueryPool creates a pool that allows up to size concurrent upstream
+// calls.
+func NewQueryPool(size int) *QueryPool {
+	return &QueryPool{sem: make(chan struct{}, size)}
+}
+
+// Acquire blocks until a concurrency slot is free or ctx is done, in which
+// case it returns ctx.Err().
+func (p *QueryPool) Acquire(ctx context.Context) error {
+	select {
+	case p.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release returns a slot acquired by Acquire.
+func (p *QueryPool) Release() {
+	<-p.sem
+}
+
+// Request returns a RequestPool that tracks every goroutine launched on
+// behalf of one coalesced fan-out (see RepositoryHandlers.produce), while
+// still sharing this pool's process-wide concurrency limit.
+func (p *QueryPool) Request() *RequestPool {
+	return &RequestPool{pool: p}
+}
+
+// RequestPool tracks the lifetime of the goroutines spawned by one
+// coalesced fan-out so its producer can wait for all of them to exit before
+// returning, instead of leaking goroutines (and the pool slots they may
+// still be waiting on) past the fan-out's own lifetime.
+type RequestPool struct {
+	pool *QueryPool
+	wg   sync.WaitGroup
+}
+
+// Go launches fn in a new goroutine tracked by this fan-out.
+func (r *RequestPool) Go(fn func()) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		fn()
+	}()
+}
+
+// WaitRequest blocks until every goroutine launched through Go has
+// returned.
+func (r *RequestPool) WaitRequest() {
+	r.wg.Wait()
+}
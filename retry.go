@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes an exponential backoff with jitter used between
+// upstream call attempts. The sleep after failed attempt N (counting from 0)
+// is computed as min(Max, Base * 2^N) * (0.5 + rand*0.5), so callers back off quickly but
+// never in lockstep with one another.
+type RetryPolicy struct {
+	Base       time.Duration
+	Max        time.Duration
+	MaxElapsed time.Duration
+}
+
+// Backoff returns the sleep duration for the given attempt number, where
+// attempt 0 is the delay before the first retry.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	d := float64(p.Base) * math.Pow(2, float64(attempt))
+	if max := float64(p.Max); d > max {
+		d = max
+	}
+	jitter := 0.5 + rand.Float64()*0.5
+	return time.Duration(d * jitter)
+}
+
+// Sleep waits for the backoff duration associated with attempt, returning
+// early with ctx.Err() if ctx is done first.
+func (p RetryPolicy) Sleep(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(p.Backoff(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
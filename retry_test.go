@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoffCapsAtMax(t *testing.T) {
+	p := RetryPolicy{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		d := p.Backoff(attempt)
+		if d > p.Max {
+			t.Fatalf("attempt %d: Backoff returned %s, want <= Max %s", attempt, d, p.Max)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffJitterStaysInRange(t *testing.T) {
+	p := RetryPolicy{Base: 100 * time.Millisecond, Max: time.Second}
+
+	// Attempt 0 is small enough that Base*2^0 never hits Max, so the jitter
+	// bounds below apply directly to the unclamped exponential value.
+	want := float64(p.Base)
+	for i := 0; i < 50; i++ {
+		d := float64(p.Backoff(0))
+		if d < want*0.5 || d > want {
+			t.Fatalf("Backoff(0) = %s, want within [%s, %s]", time.Duration(d), time.Duration(want*0.5), time.Duration(want))
+		}
+	}
+}
+
+func TestRetryPolicySleepReturnsEarlyWhenContextDone(t *testing.T) {
+	p := RetryPolicy{Base: time.Hour, Max: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := p.Sleep(ctx, 0); err != ctx.Err() {
+		t.Fatalf("Sleep returned %v, want %v", err, ctx.Err())
+	}
+}
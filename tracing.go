@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// tracer is used to create every span this service emits.
+var tracer = otel.Tracer("github.com/jcbwlkr/proxy-exercise")
+
+// setupTracing wires a TracerProvider. If otlpEndpoint is empty tracing
+// stays a no-op so it is strictly opt-in; otherwise spans are batched to the
+// given OTLP/gRPC collector. The returned shutdown func flushes and tears
+// down the exporter and should be called during graceful shutdown.
+func setupTracing(ctx context.Context, otlpEndpoint string) (shutdown func(context.Context) error, err error) {
+	if otlpEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx,
+		otlptracegrpc.WithEndpoint(otlpEndpoint),
+		otlptracegrpc.WithInsecure(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating otlp exporter")
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}